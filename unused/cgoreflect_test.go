@@ -0,0 +1,69 @@
+package unused
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCgoExportName(t *testing.T) {
+	cases := []struct {
+		comment string
+		want    string
+		wantOK  bool
+	}{
+		{"//export MyFunc", "MyFunc", true},
+		{"//export   Spaced  ", "Spaced", true},
+		{"// export NotReally", "", false},
+		{"// a regular comment", "", false},
+		{"//export ", "", false},
+	}
+	for _, c := range cases {
+		got, ok := cgoExportName(c.comment)
+		if ok != c.wantOK || got != c.want {
+			t.Errorf("cgoExportName(%q) = (%q, %v), want (%q, %v)", c.comment, got, ok, c.want, c.wantOK)
+		}
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	res := []*regexp.Regexp{
+		regexp.MustCompile(`^example\.com/widget\.Handle`),
+		regexp.MustCompile(`Test$`),
+	}
+	if !matchesAny(res, "example.com/widget.HandleRequest") {
+		t.Error("expected a match against the first pattern")
+	}
+	if !matchesAny(res, "example.com/widget.SmokeTest") {
+		t.Error("expected a match against the second pattern")
+	}
+	if matchesAny(res, "example.com/widget.Other") {
+		t.Error("expected no match")
+	}
+	if matchesAny(nil, "anything") {
+		t.Error("expected no match against an empty pattern set")
+	}
+}
+
+func TestReflectMethodByNameArgAndIsReflectType(t *testing.T) {
+	pkg := loadFixturePackage(t, `package main
+
+import "reflect"
+
+func call(v reflect.Value) {
+	v.MethodByName("DoThing")
+}
+
+func main() {}
+`)
+
+	cgoObjs, reflectNames, addrObjs := scanPackageForRoots(pkg)
+	if len(cgoObjs) != 0 {
+		t.Errorf("expected no cgo exports, got %d", len(cgoObjs))
+	}
+	if len(addrObjs) != 0 {
+		t.Errorf("expected no address-taken funcs, got %d", len(addrObjs))
+	}
+	if !reflectNames["DoThing"] {
+		t.Errorf("expected reflectNames to contain DoThing, got %v", reflectNames)
+	}
+}
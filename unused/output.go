@@ -0,0 +1,162 @@
+package unused
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// OutputFormat selects how WriteResults renders a slice of UnusedThing.
+type OutputFormat string
+
+const (
+	// OutputFormatText prints one human-readable line per thing (the
+	// default, matching UnusedThing.String()).
+	OutputFormatText OutputFormat = "text"
+	// OutputFormatJSON prints the slice as a JSON array using
+	// UnusedThing's MarshalJSON schema.
+	OutputFormatJSON OutputFormat = "json"
+	// OutputFormatSARIF prints a SARIF 2.1.0 log with one run and one
+	// result per thing, suitable for upload to code-scanning dashboards.
+	OutputFormatSARIF OutputFormat = "sarif"
+)
+
+// WriteResults renders things to w using uff.OutputFormat (default text).
+func (uff *UnusedFuncFinder) WriteResults(w io.Writer, things []UnusedThing) error {
+	switch uff.OutputFormat {
+	case OutputFormatJSON:
+		return writeJSON(w, things)
+	case OutputFormatSARIF:
+		return writeSARIF(w, things)
+	case OutputFormatText, "":
+		return writeText(w, things)
+	default:
+		return fmt.Errorf("unknown output format %q", uff.OutputFormat)
+	}
+}
+
+func writeText(w io.Writer, things []UnusedThing) error {
+	for _, t := range things {
+		if _, err := fmt.Fprintln(w, t.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, things []UnusedThing) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(things)
+}
+
+// sarifRuleID maps a Kind to the rule ID it's reported under. Things with
+// no kind (shouldn't normally happen, but keeps this total) fall back to
+// unused-func.
+func sarifRuleID(k Kind) string {
+	if k == "" {
+		return "unused-func"
+	}
+	return "unused-" + string(k)
+}
+
+// The types below are a minimal subset of the SARIF 2.1.0 object model --
+// just enough to describe "this declaration looks unused" findings.
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+func writeSARIF(w io.Writer, things []UnusedThing) error {
+	ruleSet := map[string]bool{}
+	var rules []sarifRule
+	results := make([]sarifResult, 0, len(things))
+	for _, t := range things {
+		ruleID := sarifRuleID(t.Kind)
+		if !ruleSet[ruleID] {
+			ruleSet[ruleID] = true
+			rules = append(rules, sarifRule{ID: ruleID})
+		}
+		results = append(results, sarifResult{
+			RuleID: ruleID,
+			Level:  "warning",
+			Message: sarifMessage{
+				Text: fmt.Sprintf("%s %q appears to be unused", t.Kind, t.Name),
+			},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: t.File},
+					Region: sarifRegion{
+						StartLine:   t.Line,
+						StartColumn: t.Column,
+					},
+				},
+			}},
+		})
+	}
+
+	log := sarifLog{
+		Schema:  "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:  "go-oracle-tools/unused",
+				Rules: rules,
+			}},
+			Results: results,
+		}},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
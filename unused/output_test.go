@@ -0,0 +1,100 @@
+package unused
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func exampleThings() []UnusedThing {
+	return []UnusedThing{
+		{Name: "DeadFunc", Kind: KindFunc, Package: "example.com/widget", File: "widget.go", Line: 10, Column: 1},
+		{Name: "deadHelper", Kind: KindMethod, Package: "example.com/widget", File: "widget.go", Line: 20, Column: 1},
+	}
+}
+
+func TestWriteResultsJSON(t *testing.T) {
+	uff := NewUnusedFunctionFinder()
+	uff.OutputFormat = OutputFormatJSON
+
+	var buf bytes.Buffer
+	if err := uff.WriteResults(&buf, exampleThings()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []struct {
+		Name     string `json:"name"`
+		Kind     string `json:"kind"`
+		Package  string `json:"package"`
+		File     string `json:"file"`
+		Line     int    `json:"line"`
+		Column   int    `json:"column"`
+		Exported bool   `json:"exported"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal: %v\noutput: %s", err, buf.String())
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].Name != "DeadFunc" || !got[0].Exported {
+		t.Errorf("entry 0 = %+v, want exported DeadFunc", got[0])
+	}
+	if got[1].Name != "deadHelper" || got[1].Exported {
+		t.Errorf("entry 1 = %+v, want unexported deadHelper", got[1])
+	}
+}
+
+func TestWriteResultsSARIF(t *testing.T) {
+	uff := NewUnusedFunctionFinder()
+	uff.OutputFormat = OutputFormatSARIF
+
+	var buf bytes.Buffer
+	if err := uff.WriteResults(&buf, exampleThings()); err != nil {
+		t.Fatal(err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("unmarshal: %v\noutput: %s", err, buf.String())
+	}
+	if len(log.Runs) != 1 {
+		t.Fatalf("got %d runs, want 1", len(log.Runs))
+	}
+	run := log.Runs[0]
+	if len(run.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(run.Results))
+	}
+	if run.Results[0].RuleID != "unused-func" {
+		t.Errorf("result 0 ruleId = %q, want unused-func", run.Results[0].RuleID)
+	}
+	if run.Results[1].RuleID != "unused-method" {
+		t.Errorf("result 1 ruleId = %q, want unused-method", run.Results[1].RuleID)
+	}
+	if len(run.Tool.Driver.Rules) != 2 {
+		t.Errorf("got %d distinct rules, want 2", len(run.Tool.Driver.Rules))
+	}
+}
+
+func TestWriteResultsText(t *testing.T) {
+	uff := NewUnusedFunctionFinder()
+
+	var buf bytes.Buffer
+	if err := uff.WriteResults(&buf, exampleThings()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "DeadFunc") {
+		t.Errorf("expected text output to mention DeadFunc, got: %s", buf.String())
+	}
+}
+
+func TestWriteResultsUnknownFormat(t *testing.T) {
+	uff := NewUnusedFunctionFinder()
+	uff.OutputFormat = OutputFormat("yaml")
+
+	var buf bytes.Buffer
+	if err := uff.WriteResults(&buf, exampleThings()); err == nil {
+		t.Error("expected an error for an unknown output format")
+	}
+}
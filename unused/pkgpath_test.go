@@ -0,0 +1,96 @@
+package unused
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindGoMod(t *testing.T) {
+	dir := writeFixtureModule(t, map[string]string{
+		"go.mod":         "module example.com/widget\n\ngo 1.21\n",
+		"sub/pkg/foo.go": "package pkg\n",
+	})
+
+	modDir, modPath, ok := findGoMod(filepath.Join(dir, "sub", "pkg"))
+	if !ok {
+		t.Fatal("expected to find go.mod")
+	}
+	if modDir != dir {
+		t.Errorf("modDir = %q, want %q", modDir, dir)
+	}
+	if modPath != "example.com/widget" {
+		t.Errorf("modPath = %q, want example.com/widget", modPath)
+	}
+
+	if _, _, ok := findGoMod(t.TempDir()); ok {
+		t.Error("expected no go.mod to be found in an empty temp dir")
+	}
+}
+
+func TestModulePkgName(t *testing.T) {
+	dir := writeFixtureModule(t, map[string]string{
+		"go.mod":         "module example.com/widget\n\ngo 1.21\n",
+		"sub/pkg/foo.go": "package pkg\n",
+	})
+
+	name, ok, err := modulePkgName(filepath.Join(dir, "sub", "pkg", "foo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected modulePkgName to find the go.mod")
+	}
+	if want := "example.com/widget/sub/pkg"; name != want {
+		t.Errorf("modulePkgName = %q, want %q", name, want)
+	}
+
+	_, ok, err = modulePkgName(filepath.Join(t.TempDir(), "foo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("expected ok=false with no go.mod above the file")
+	}
+}
+
+func TestGopathPkgName(t *testing.T) {
+	gopath := t.TempDir()
+	t.Setenv("GOPATH", gopath)
+
+	srcDir := filepath.Join(gopath, "src", "example.com", "widget")
+	if err := os.MkdirAll(srcDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(srcDir, "foo.go")
+	if err := os.WriteFile(file, []byte("package widget\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := gopathPkgName(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "example.com/widget"; name != want {
+		t.Errorf("gopathPkgName = %q, want %q", name, want)
+	}
+
+	if _, err := gopathPkgName(filepath.Join(t.TempDir(), "foo.go")); err == nil {
+		t.Error("expected an error for a file outside GOPATH/src")
+	}
+}
+
+func TestAllFilesModuled(t *testing.T) {
+	dir := writeFixtureModule(t, map[string]string{
+		"go.mod":    "module example.com/widget\n\ngo 1.21\n",
+		"foo.go":    "package widget\n",
+		"sub/go.go": "package sub\n",
+	})
+
+	if !allFilesModuled([]string{filepath.Join(dir, "foo.go")}) {
+		t.Error("expected file under a go.mod to count as moduled")
+	}
+	if allFilesModuled([]string{filepath.Join(t.TempDir(), "foo.go")}) {
+		t.Error("expected a file with no go.mod above it to not count as moduled")
+	}
+}
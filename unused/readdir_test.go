@@ -0,0 +1,83 @@
+package unused
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestSkipDirName(t *testing.T) {
+	cases := map[string]bool{
+		"vendor":   true,
+		"testdata": true,
+		".git":     true,
+		".hidden":  true,
+		"pkg":      false,
+		"cmd":      false,
+	}
+	for name, want := range cases {
+		if got := skipDirName(name); got != want {
+			t.Errorf("skipDirName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCanReadSourceFile(t *testing.T) {
+	uff := NewUnusedFunctionFinder()
+	uff.Ignore = `_test\.go$,generated_.*\.go$`
+
+	cases := map[string]bool{
+		"foo.go":             true,
+		"foo.txt":            false,
+		"foo_test.go":        false,
+		"generated_a.go":     false,
+		"sub/generated_b.go": false,
+	}
+	for path, want := range cases {
+		if got := uff.canReadSourceFile(path); got != want {
+			t.Errorf("canReadSourceFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestCanReadSourceFileInvalidPattern(t *testing.T) {
+	uff := NewUnusedFunctionFinder()
+	uff.Ignore = `(unterminated`
+
+	// an invalid pattern is logged and skipped, not fatal; every .go file
+	// should still be readable.
+	if !uff.canReadSourceFile("foo.go") {
+		t.Error("expected foo.go to still be readable despite the invalid ignore pattern")
+	}
+}
+
+func TestReadDirParallel(t *testing.T) {
+	dir := writeFixtureModule(t, map[string]string{
+		"go.mod":         "module fixture\n\ngo 1.21\n",
+		"a.go":           "package fixture\n\nfunc Alpha() {}\n",
+		"b.go":           "package fixture\n\nfunc Beta() {}\n",
+		"vendor/skip.go": "package vendored\n\nfunc Vendored() {}\n",
+		"testdata/x.go":  "package testdata\n\nfunc Fixture() {}\n",
+		"notgo.txt":      "not a go file",
+	})
+
+	uff := NewUnusedFunctionFinder()
+	if err := uff.readDir(dir); err != nil {
+		t.Fatalf("readDir: %v", err)
+	}
+
+	var names []string
+	for _, thing := range uff.things {
+		names = append(names, thing.Name)
+	}
+	sort.Strings(names)
+
+	want := []string{"Alpha", "Beta"}
+	if len(names) != len(want) {
+		t.Fatalf("got funcs %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("got funcs %v, want %v", names, want)
+		}
+	}
+}
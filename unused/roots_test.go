@@ -0,0 +1,75 @@
+package unused
+
+import (
+	"go/token"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// loadFixturePackage type-checks a single-file package from source, for
+// tests that need a *packages.Package but not a full go/ssa build.
+func loadFixturePackage(t *testing.T, src string) *packages.Package {
+	t.Helper()
+	dir := writeFixtureModule(t, map[string]string{
+		"go.mod":  "module fixture\n\ngo 1.21\n",
+		"main.go": src,
+	})
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+			packages.NeedImports | packages.NeedDeps | packages.NeedTypes |
+			packages.NeedTypesInfo | packages.NeedSyntax,
+		Dir:  dir,
+		Fset: token.NewFileSet(),
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		t.Fatalf("loading fixture package: %v", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 || len(pkgs) != 1 {
+		t.Fatalf("fixture package failed to load cleanly: %d pkgs", len(pkgs))
+	}
+	return pkgs[0]
+}
+
+// TestScanPackageForRootsIgnoresLocalVars covers the address-taken
+// heuristic: a local variable inside a function body whose name happens to
+// start with an uppercase letter must not be treated as an exported
+// package-level var, even though *ast.GenDecl with Tok==VAR also matches it.
+func TestScanPackageForRootsIgnoresLocalVars(t *testing.T) {
+	pkg := loadFixturePackage(t, `package main
+
+func helper() int { return 1 }
+
+func other() {
+	var Local = helper
+	_ = Local()
+}
+
+func main() {}
+`)
+
+	_, _, addrObjs := scanPackageForRoots(pkg)
+	for obj := range addrObjs {
+		t.Errorf("local var capitalization should not root %v as an address-taken func", obj)
+	}
+}
+
+// TestScanPackageForRootsFindsExportedVar is the positive case: a function's
+// address genuinely stashed in a package-level exported var must still be
+// found.
+func TestScanPackageForRootsFindsExportedVar(t *testing.T) {
+	pkg := loadFixturePackage(t, `package main
+
+func helper() int { return 1 }
+
+var Hook = helper
+
+func main() {}
+`)
+
+	_, _, addrObjs := scanPackageForRoots(pkg)
+	if len(addrObjs) != 1 {
+		t.Fatalf("expected exactly one address-taken func, got %d", len(addrObjs))
+	}
+}
@@ -1,60 +1,184 @@
-// The "unused" package wraps the go 'oracle' tool and provides
-// hooks for finding unused functions in a codebase
+// The "unused" package loads a Go program with golang.org/x/tools/go/packages,
+// builds its SSA form, and walks a callgraph to find declared functions that
+// are never called.
 package unused
 
 import (
+	"encoding/json"
 	"fmt"
 	"go/ast"
-	"go/build"
 	"go/parser"
 	"go/token"
-	"golang.org/x/tools/oracle"
-	"golang.org/x/tools/oracle/serial"
+	"go/types"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/callgraph/static"
+	"golang.org/x/tools/go/callgraph/vta"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
 )
 
 var NICE = 2
 
+// CallgraphMode selects the algorithm used to build the whole-program
+// callgraph that unused functions are checked against.
+type CallgraphMode string
+
+const (
+	// CallgraphModeCHA builds the callgraph with class hierarchy analysis.
+	// It is the cheapest mode and doesn't require a main package, but it
+	// over-approximates dynamic dispatch.
+	CallgraphModeCHA CallgraphMode = "cha"
+	// CallgraphModeRTA builds the callgraph with rapid type analysis,
+	// seeded from the program's main/init functions. More precise than
+	// CHA, but requires at least one main package.
+	CallgraphModeRTA CallgraphMode = "rta"
+	// CallgraphModeStatic only follows static (non-interface, non-func-value)
+	// calls. Fastest and least complete.
+	CallgraphModeStatic CallgraphMode = "static"
+	// CallgraphModePointer builds the callgraph with variable type analysis
+	// (go/callgraph/vta). It supersedes the old constraint-based pointer
+	// analysis in golang.org/x/tools/go/pointer, which was removed upstream;
+	// the mode keeps the old name since it fills the same "most precise"
+	// slot.
+	CallgraphModePointer CallgraphMode = "pointer"
+)
+
+// BuildMode selects how input files are mapped to their enclosing package
+// path: via the nearest go.mod, via GOPATH, or automatically (trying
+// go.mod first and falling back to GOPATH).
+type BuildMode string
+
+const (
+	BuildModeAuto   BuildMode = "auto"
+	BuildModeModule BuildMode = "module"
+	BuildModeGOPATH BuildMode = "gopath"
+)
+
+// Kind identifies what sort of declaration an UnusedThing refers to.
+type Kind string
+
+const (
+	KindFunc   Kind = "func"
+	KindMethod Kind = "method"
+	KindType   Kind = "type"
+	KindVar    Kind = "var"
+	KindConst  Kind = "const"
+	KindField  Kind = "field"
+)
+
 type UnusedThing struct {
-	Name string
-	File string
+	Name    string
+	File    string
+	Kind    Kind
+	Package string
+	Line    int
+	Column  int
+
+	// pos is the declaration's position, used to match this thing against
+	// the callgraph without relying on fragile path substring matching.
+	pos token.Position
+
+	// obj is the types.Object backing things found by the type-checked pass
+	// (methods, types, vars, consts, fields), used to check non-func/method
+	// kinds for references via types.Info.Uses. Funcs and methods are
+	// checked against the callgraph instead; obj is nil for plain funcs,
+	// which come from the AST pass.
+	obj types.Object
 }
 
 func (ut UnusedThing) String() string {
+	if ut.Kind != "" {
+		return fmt.Sprintf("%s %s in '%s'", ut.Kind, ut.Name, ut.File)
+	}
 	if ut.File != "" {
 		return fmt.Sprintf("%s in '%s'", ut.Name, ut.File)
 	}
 	return ut.Name
 }
 
+// Exported reports whether this declaration is part of its package's
+// exported API.
+func (ut UnusedThing) Exported() bool {
+	return ast.IsExported(ut.Name)
+}
+
+// MarshalJSON emits the schema used by OutputFormatJSON: name, kind,
+// package, file, line, column, and exported.
+func (ut UnusedThing) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name     string `json:"name"`
+		Kind     Kind   `json:"kind"`
+		Package  string `json:"package"`
+		File     string `json:"file"`
+		Line     int    `json:"line"`
+		Column   int    `json:"column"`
+		Exported bool   `json:"exported"`
+	}{
+		Name:     ut.Name,
+		Kind:     ut.Kind,
+		Package:  ut.Package,
+		File:     ut.File,
+		Line:     ut.Line,
+		Column:   ut.Column,
+		Exported: ut.Exported(),
+	})
+}
+
 type UnusedFuncFinder struct {
-	Callgraph []serial.CallGraph
+	Callgraph     *callgraph.Graph
+	CallgraphMode CallgraphMode
+	BuildMode     BuildMode
+	OutputFormat  OutputFormat
 
-	Ignore        string
-	Verbose       bool
-	IncludeAll    bool
-	LogWriter     io.Writer
-	CallgraphJSON string // for setting user json input (hack?)
+	// Ignore is a comma-separated list of regex patterns; any source path
+	// matching one of them is skipped.
+	Ignore     string
+	Verbose    bool
+	IncludeAll bool
+	LogWriter  io.Writer
 
-	Idents       bool
 	ExportedOnly bool
 	SkipMethods  bool
 
-	filesByCaller map[string][]string
-	pkgs          map[string]struct{}
-	funcs         []UnusedThing
-	numFilesRead  int
+	// RootPatterns are regexes matched against "pkg.Func" for every
+	// function in the program; matches are treated as reachable roots even
+	// with no visible caller, alongside the built-in reflect/cgo/address-
+	// taken heuristics in findExtraRoots.
+	RootPatterns []string
+
+	mu           sync.Mutex
+	fset         *token.FileSet
+	pkgs         map[string]struct{}
+	things       []UnusedThing
+	numFilesRead int
+	ignoreRes    []*regexp.Regexp
+	ignoreOnce   sync.Once
+	loadDir      string
+
+	calledPositions map[token.Position]bool
+	usedObjs        map[types.Object]bool
+	taggedFields    map[types.Object]bool
+	extraRoots      []*ssa.Function
 }
 
 func NewUnusedFunctionFinder() *UnusedFuncFinder {
 	return &UnusedFuncFinder{
 		// init private storage
-		pkgs:          map[string]struct{}{},
-		filesByCaller: map[string][]string{},
-		funcs:         []UnusedThing{},
+		pkgs:   map[string]struct{}{},
+		things: []UnusedThing{},
 		// default to stderr; this can be overwritten before Run() is called
 		LogWriter: os.Stderr,
 	}
@@ -91,95 +215,416 @@ func (uff *UnusedFuncFinder) pkgsAsArray() []string {
 	return packages
 }
 
-func (uff *UnusedFuncFinder) getCallgraphFromOracle() error {
-	res, err := oracle.Query(uff.pkgsAsArray(), "callgraph", "", nil, &build.Default, true)
+// analyzeProgram loads the packages collected via AddPkg with go/packages,
+// builds their SSA representation and whole-program callgraph (using
+// uff.CallgraphMode, default CHA), and collects the declared methods,
+// types, vars, consts, and fields those packages' type information knows
+// about alongside the reference set used to tell which of them are used.
+func (uff *UnusedFuncFinder) analyzeProgram() error {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+			packages.NeedImports | packages.NeedDeps | packages.NeedTypes |
+			packages.NeedTypesInfo | packages.NeedSyntax,
+		Dir:  uff.loadDir,
+		Fset: token.NewFileSet(),
+	}
+	uff.fset = cfg.Fset
+
+	pkgs, err := packages.Load(cfg, uff.pkgsAsArray()...)
 	if err != nil {
-		return err
+		return fmt.Errorf("loading packages: %v", err)
 	}
-	serialRes := res.Serial()
-	if serialRes.Callgraph == nil {
-		return fmt.Errorf("no callgraph present in oracle results")
+	if packages.PrintErrors(pkgs) > 0 {
+		return fmt.Errorf("one or more packages failed to load")
 	}
-	uff.Callgraph = serialRes.Callgraph
+
+	prog, ssaPkgs := ssautil.AllPackages(pkgs, ssa.InstantiateGenerics)
+	prog.Build()
+
+	switch uff.CallgraphMode {
+	case CallgraphModeRTA:
+		roots, err := mainFuncs(ssaPkgs)
+		if err != nil {
+			return err
+		}
+		uff.Callgraph = rta.Analyze(roots, true).CallGraph
+	case CallgraphModeStatic:
+		uff.Callgraph = static.CallGraph(prog)
+	case CallgraphModePointer:
+		uff.Callgraph = vta.CallGraph(ssautil.AllFunctions(prog), cha.CallGraph(prog))
+	case CallgraphModeCHA, "":
+		uff.Callgraph = cha.CallGraph(prog)
+	default:
+		return fmt.Errorf("unknown callgraph mode %q", uff.CallgraphMode)
+	}
+
+	uff.collectDeclaredThings(pkgs)
+	uff.usedObjs = collectUsedObjs(pkgs)
+	uff.taggedFields = collectTaggedFields(pkgs)
+	uff.extraRoots = uff.findExtraRoots(pkgs, prog)
 	return nil
 }
 
+// collectDeclaredThings walks the type-checked syntax of pkgs to record
+// methods, the types, consts, and vars declared at package scope, and
+// struct fields, complementing the plain funcs already gathered by
+// readFuncsAndImportsFromFile.
+func (uff *UnusedFuncFinder) collectDeclaredThings(pkgs []*packages.Package) {
+	for _, pkg := range pkgs {
+		scope := pkg.Types.Scope()
+		for ident, obj := range pkg.TypesInfo.Defs {
+			if obj == nil || ident.Name == "_" {
+				continue
+			}
+			kind, ok := uff.kindOf(obj, scope)
+			if !ok {
+				continue
+			}
+			pos := declPosition(uff.fset, ident.Pos())
+			uff.things = append(uff.things, UnusedThing{
+				Name:    ident.Name,
+				File:    pos.Filename,
+				Kind:    kind,
+				Package: pkg.PkgPath,
+				Line:    pos.Line,
+				Column:  pos.Column,
+				pos:     pos,
+				obj:     obj,
+			})
+		}
+	}
+}
+
+// kindOf classifies a type-checked object into the Kind it should be
+// reported as, or reports ok=false if it's not a package-level declaration
+// this tool tracks (e.g. a local variable, or a plain func already picked
+// up by the AST pass). Struct fields are reported as KindField wherever
+// their struct type is declared, including inside function bodies.
+func (uff *UnusedFuncFinder) kindOf(obj types.Object, pkgScope *types.Scope) (Kind, bool) {
+	switch o := obj.(type) {
+	case *types.Func:
+		sig, _ := o.Type().(*types.Signature)
+		if sig == nil || sig.Recv() == nil {
+			return "", false // plain funcs come from the AST pass
+		}
+		if uff.SkipMethods {
+			return "", false
+		}
+		return KindMethod, true
+	case *types.Var:
+		if o.IsField() {
+			return KindField, true
+		}
+		if o.Parent() != pkgScope {
+			return "", false
+		}
+		return KindVar, true
+	case *types.Const:
+		if o.Parent() != pkgScope {
+			return "", false
+		}
+		return KindConst, true
+	case *types.TypeName:
+		if o.Parent() != pkgScope || o.IsAlias() {
+			return "", false
+		}
+		return KindType, true
+	}
+	return "", false
+}
+
+// collectUsedObjs records every object referenced (as opposed to declared)
+// anywhere in pkgs, used to tell whether a type/var/const/field is unused.
+func collectUsedObjs(pkgs []*packages.Package) map[types.Object]bool {
+	used := map[types.Object]bool{}
+	for _, pkg := range pkgs {
+		for _, obj := range pkg.TypesInfo.Uses {
+			used[obj] = true
+		}
+	}
+	return used
+}
+
+// collectTaggedFields records the types.Object for every struct field whose
+// declaration carries a tag, e.g. `json:"name"`. encoding/json and similar
+// reflection-based marshalers reach these fields through the tag, not
+// through a reference this tool's static types.Info.Uses pass can see, so
+// they're held live the same way isExportedRoot holds exported names live.
+func collectTaggedFields(pkgs []*packages.Package) map[types.Object]bool {
+	tagged := map[types.Object]bool{}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				st, ok := n.(*ast.StructType)
+				if !ok {
+					return true
+				}
+				for _, field := range st.Fields.List {
+					if field.Tag == nil || field.Tag.Value == "" {
+						continue
+					}
+					for _, name := range field.Names {
+						if obj := pkg.TypesInfo.Defs[name]; obj != nil {
+							tagged[obj] = true
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	return tagged
+}
+
+// mainFuncs returns the main.main function and the package initializer of
+// every main package among pkgs, for use as RTA roots.
+func mainFuncs(pkgs []*ssa.Package) ([]*ssa.Function, error) {
+	var roots []*ssa.Function
+	for _, pkg := range pkgs {
+		if pkg == nil || pkg.Pkg.Name() != "main" {
+			continue
+		}
+		if main := pkg.Func("main"); main != nil {
+			roots = append(roots, main)
+		}
+		if initFn := pkg.Func("init"); initFn != nil {
+			roots = append(roots, initFn)
+		}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("rta callgraph mode requires at least one main package")
+	}
+	return roots, nil
+}
+
 func (uff *UnusedFuncFinder) readFuncsAndImportsFromFile(filename string) error {
 
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+
 	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, filename, nil, 0)
+	f, err := parser.ParseFile(fset, abs, nil, 0)
 	if err != nil {
 		return err
 	}
 
-	// check if this is a main packages or
-	// if we want to analyze everything
+	// check if this is a main packages or if we want to analyze everything;
+	// do this before taking uff.mu since getFullPkgName only touches the
+	// filesystem
+	var pkgName string
 	if f.Name.Name == "main" || uff.IncludeAll {
-		pkgName, err := getFullPkgName(filename)
+		pkgName, err = uff.getFullPkgName(filename)
 		if err != nil {
 			return fmt.Errorf("error getting main package path: %v", err)
 		}
-		uff.AddPkg(pkgName)
 	}
 
-	// iterate over the AST, tracking found functions
+	// best-effort full package path for the Package field on reported
+	// things; failures here are non-fatal, unlike the main/IncludeAll case
+	// above which feeds the callgraph package set
+	pkgPath := pkgName
+	if pkgPath == "" {
+		if p, perr := uff.getFullPkgName(filename); perr == nil {
+			pkgPath = p
+		} else {
+			pkgPath = f.Name.Name
+		}
+	}
+
+	// gather found functions locally; parsing itself needs no lock, only
+	// the merge into uff's shared state below does. Methods are left to the
+	// types-aware pass in collectDeclaredThings, which has the receiver
+	// type information needed to report them properly.
+	var found []UnusedThing
 	ast.Inspect(f, func(n ast.Node) bool {
-		var s string
-		switch node := n.(type) {
-		case *ast.FuncDecl:
-			asFunc := node
-			s = asFunc.Name.String()
-		}
-		if s != "" {
-			switch {
-			//TODO make this a helper
-			case strings.Contains(s, "Test"):
-			case s == "main":
-			case s == "init":
-			case s == "test":
-			default:
-				uff.funcs = append(uff.funcs, UnusedThing{s, filename})
-			}
+		asFunc, ok := n.(*ast.FuncDecl)
+		if !ok {
+			return true
+		}
+		if asFunc.Recv != nil {
+			return true
+		}
+		s := asFunc.Name.String()
+		switch {
+		//TODO make this a helper
+		case strings.Contains(s, "Test"):
+		case s == "main":
+		case s == "init":
+		case s == "test":
+		default:
+			pos := declPosition(fset, asFunc.Name.Pos())
+			found = append(found, UnusedThing{
+				Name:    s,
+				File:    filename,
+				Kind:    KindFunc,
+				Package: pkgPath,
+				Line:    pos.Line,
+				Column:  pos.Column,
+				pos:     pos,
+			})
 		}
 		return true
 	})
 
+	uff.mu.Lock()
+	defer uff.mu.Unlock()
+	if pkgName != "" {
+		uff.AddPkg(pkgName)
+	}
+	uff.things = append(uff.things, found...)
 	uff.numFilesRead++
 	return nil
 }
 
-func (uff *UnusedFuncFinder) computeUnusedFuncs() []UnusedThing {
+// declPosition returns the position of pos with its byte offset zeroed out,
+// so that positions recorded from independent token.FileSets (one per parsed
+// file here, one shared across the whole program in the callgraph) can still
+// be compared for equality by filename/line/column.
+func declPosition(fset *token.FileSet, pos token.Pos) token.Position {
+	p := fset.Position(pos)
+	p.Offset = 0
+	return p
+}
+
+// computeUnusedThings filters uff.things down to those with no live caller
+// (for funcs/methods) or reference (for types/vars/consts/fields), skipping
+// anything rooted as exported API.
+func (uff *UnusedFuncFinder) computeUnusedThings() []UnusedThing {
 	unused := []UnusedThing{}
-	for _, f := range uff.funcs {
-		if !uff.isInCG(f) {
-			unused = append(unused, f)
+	for _, t := range uff.things {
+		if uff.isExportedRoot(t) {
+			continue
+		}
+		if uff.isLive(t) {
+			continue
 		}
+		unused = append(unused, t)
 	}
 	return unused
 }
 
+// isExportedRoot reports whether t should be treated as reachable because
+// it's part of the package's exported API. Exported identifiers only count
+// as roots when ExportedOnly is false (the default) -- ExportedOnly asks
+// this tool to hold exported declarations to the same bar as everything
+// else, e.g. inside a repo whose packages have no external consumers.
+func (uff *UnusedFuncFinder) isExportedRoot(t UnusedThing) bool {
+	return !uff.ExportedOnly && ast.IsExported(t.Name)
+}
+
+// isLive reports whether t has a live caller (funcs/methods, via the
+// callgraph), a reference (everything else, via types.Info.Uses), or, for
+// struct fields, a tag that a reflection-based marshaler could reach it
+// through.
+func (uff *UnusedFuncFinder) isLive(t UnusedThing) bool {
+	switch t.Kind {
+	case KindFunc, KindMethod, "":
+		return uff.isInCG(t)
+	default:
+		return t.obj != nil && (uff.usedObjs[t.obj] || uff.taggedFields[t.obj])
+	}
+}
+
 func (uff *UnusedFuncFinder) isInCG(f UnusedThing) bool {
-	files, ok := uff.filesByCaller[f.Name]
-	if !ok {
-		return false
+	return uff.calledPositions[f.pos]
+}
+
+// buildFileMap records, for every callgraph node with at least one real
+// caller, the declaration position of the underlying function. isInCG then
+// matches declared functions against this set by token.Position instead of
+// the old strings.Contains(path, f.File) heuristic.
+func (uff *UnusedFuncFinder) buildFileMap() {
+	uff.calledPositions = map[token.Position]bool{}
+	for fn := range uff.reachableCallees() {
+		uff.calledPositions[declPosition(uff.fset, fn.Pos())] = true
 	}
-	for _, path := range files {
-		if strings.Contains(path, f.File) {
-			return true
+	uff.markReachable(uff.extraRoots)
+}
+
+// reachableCallees returns every callgraph function with at least one
+// incoming edge that counts as a real call. A node's raw node.In can't be trusted on its own:
+// go/ssa synthesizes wrapper functions for method values and interface
+// satisfaction (e.g. the *T wrapper around a value-receiver method), and
+// those wrappers get a static edge into the real method even when the
+// wrapper itself is never called. Treating "any incoming edge" as "called"
+// lets that phantom edge mark an actually-dead method as live. Instead, only
+// count an edge whose caller is a normal (non-synthetic) function, a
+// synthetic package initializer (which runs package-level var
+// initializers and init funcs), or another synthetic function that is
+// itself reachable by this same rule -- computed as a fixed point since a
+// handful of wrapper kinds can chain.
+func (uff *UnusedFuncFinder) reachableCallees() map[*ssa.Function]bool {
+	reached := map[*ssa.Function]bool{}
+	for changed := true; changed; {
+		changed = false
+		for fn, node := range uff.Callgraph.Nodes {
+			if fn == nil || reached[fn] {
+				continue
+			}
+			for _, edge := range node.In {
+				caller := edge.Caller.Func
+				if caller == nil {
+					continue
+				}
+				if caller.Synthetic == "" || caller.Synthetic == "package initializer" || reached[caller] {
+					reached[fn] = true
+					changed = true
+					break
+				}
+			}
 		}
 	}
-	return false
+	return reached
 }
 
-func (uff *UnusedFuncFinder) buildFileMap() {
-	for _, entry := range uff.Callgraph {
-		//strip off the package name for simplicity
-		//TODO, can this be left on? Try prepending func names with package?
-		idx := strings.LastIndex(entry.Name, ".") + 1
-		if idx != 0 {
-			uff.filesByCaller[entry.Name[idx:]] = append(uff.filesByCaller[entry.Name[idx:]], entry.Pos)
+// markReachable walks the callgraph outward from roots, recording every
+// function it finds as called. Roots come from findExtraRoots -- reflect,
+// cgo, and address-taken functions the static callgraph alone can't see as
+// having a caller -- so without this pass they'd be reported unused despite
+// being reachable in practice.
+func (uff *UnusedFuncFinder) markReachable(roots []*ssa.Function) {
+	seen := map[*ssa.Function]bool{}
+	var visit func(fn *ssa.Function)
+	visit = func(fn *ssa.Function) {
+		if fn == nil || seen[fn] {
+			return
+		}
+		seen[fn] = true
+		uff.calledPositions[declPosition(uff.fset, fn.Pos())] = true
+		node := uff.Callgraph.Nodes[fn]
+		if node == nil {
+			return
+		}
+		for _, edge := range node.Out {
+			if edge.Callee != nil {
+				visit(edge.Callee.Func)
+			}
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+}
+
+// allFilesModuled reports whether every entry in fileArgs is covered by a
+// go.mod, so Run can skip its GOPATH requirement.
+func allFilesModuled(fileArgs []string) bool {
+	for _, filename := range fileArgs {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return false
+		}
+		dir := abs
+		if !isDir(abs) {
+			dir = filepath.Dir(abs)
+		}
+		if _, _, ok := findGoMod(dir); !ok {
+			return false
 		}
 	}
+	return true
 }
 
 // helper for directory traversal
@@ -188,8 +633,75 @@ func isDir(filename string) bool {
 	return err == nil && fi.IsDir()
 }
 
-// helper for grabbing package name from its folder
-func getFullPkgName(filename string) (string, error) {
+// getFullPkgName resolves the import path of the package enclosing filename.
+// In BuildModeAuto (the default) it prefers the nearest go.mod and falls
+// back to GOPATH; BuildModeModule and BuildModeGOPATH pin it to one or the
+// other.
+func (uff *UnusedFuncFinder) getFullPkgName(filename string) (string, error) {
+	mode := uff.BuildMode
+	if mode == "" {
+		mode = BuildModeAuto
+	}
+
+	if mode != BuildModeGOPATH {
+		pkgName, ok, err := modulePkgName(filename)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return pkgName, nil
+		}
+		if mode == BuildModeModule {
+			return "", fmt.Errorf("no go.mod found above %q", filename)
+		}
+	}
+
+	return gopathPkgName(filename)
+}
+
+// modulePkgName computes the import path of the package enclosing filename
+// by locating the nearest go.mod above it and joining its module path with
+// the relative directory. ok is false when no go.mod is found.
+func modulePkgName(filename string) (pkgName string, ok bool, err error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", false, err
+	}
+
+	modDir, modPath, ok := findGoMod(filepath.Dir(abs))
+	if !ok {
+		return "", false, nil
+	}
+
+	rel, err := filepath.Rel(modDir, filepath.Dir(abs))
+	if err != nil {
+		return "", false, err
+	}
+	if rel == "." {
+		return modPath, true, nil
+	}
+	return path.Join(modPath, filepath.ToSlash(rel)), true, nil
+}
+
+// findGoMod walks up from dir looking for a go.mod file, returning the
+// directory it was found in and its declared module path.
+func findGoMod(dir string) (modDir, modPath string, ok bool) {
+	for {
+		data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			return dir, modfile.ModulePath(data), true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", false
+		}
+		dir = parent
+	}
+}
+
+// gopathPkgName is the original GOPATH-only resolution, kept as the fallback
+// for trees without a go.mod.
+func gopathPkgName(filename string) (string, error) {
 	abs, err := filepath.Abs(filename)
 	if err != nil {
 		return "", err
@@ -203,15 +715,35 @@ func getFullPkgName(filename string) (string, error) {
 		stripped := strings.TrimPrefix(abs, p)
 		return filepath.Dir(stripped), nil
 	}
-	// a check during initialization ensures that GOPATH != "" so this
-	// should be safe
-	return "", fmt.Errorf("cd %q and try again", goPaths[len(goPaths)-1])
+	return "", fmt.Errorf("cd %q and try again, or add a go.mod covering it", filename)
+}
+
+// compiledIgnorePatterns lazily compiles uff.Ignore into one regexp per
+// comma-separated pattern, once, regardless of how many goroutines call
+// canReadSourceFile concurrently.
+func (uff *UnusedFuncFinder) compiledIgnorePatterns() []*regexp.Regexp {
+	uff.ignoreOnce.Do(func() {
+		if uff.Ignore == "" {
+			return
+		}
+		for _, pat := range strings.Split(uff.Ignore, ",") {
+			re, err := regexp.Compile(pat)
+			if err != nil {
+				uff.Errorf("Ignoring invalid pattern %q: %v", pat, err)
+				continue
+			}
+			uff.ignoreRes = append(uff.ignoreRes, re)
+		}
+	})
+	return uff.ignoreRes
 }
 
 func (uff *UnusedFuncFinder) canReadSourceFile(filename string) bool {
-	if uff.Ignore != "" && strings.Contains(filename, uff.Ignore) { //TODO regex
-		uff.Logf("Ignoring path '%v'", filename)
-		return false
+	for _, re := range uff.compiledIgnorePatterns() {
+		if re.MatchString(filename) {
+			uff.Logf("Ignoring path '%v'", filename)
+			return false
+		}
 	}
 	if !strings.HasSuffix(filename, ".go") {
 		return false
@@ -225,14 +757,66 @@ func isNotStandardLibrary(pkg string) bool {
 	return strings.ContainsRune(pkg, '.')
 }
 
+// skipDirName reports whether readDir skips a directory named name:
+// vendored code, test fixtures, and hidden directories (.git and friends).
+func skipDirName(name string) bool {
+	return name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".")
+}
+
+// readDir walks dirname and parses every source file it finds, fanning the
+// parsing out across NICE worker goroutines (mirroring the bounded worker
+// pool golang.org/x/tools/internal/gopathwalk uses for directory scans).
+// Results are merged into uff.things/uff.pkgs under uff.mu as each worker
+// finishes a file.
 func (uff *UnusedFuncFinder) readDir(dirname string) error {
-	err := filepath.Walk(dirname, func(path string, info os.FileInfo, err error) error {
-		if err == nil && !info.IsDir() && uff.canReadSourceFile(path) {
-			err = uff.readFuncsAndImportsFromFile(path)
+	paths := make(chan string)
+	errs := make(chan error, 1)
+
+	workers := NICE
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if err := uff.readFuncsAndImportsFromFile(path); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	walkErr := filepath.Walk(dirname, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-		return err
+		if info.IsDir() {
+			if path != dirname && skipDirName(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if uff.canReadSourceFile(path) {
+			paths <- path
+		}
+		return nil
 	})
-	return err
+	close(paths)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	return walkErr
 }
 
 func (uff *UnusedFuncFinder) Run(fileArgs []string) ([]UnusedThing, error) {
@@ -242,11 +826,21 @@ func (uff *UnusedFuncFinder) Run(fileArgs []string) ([]UnusedThing, error) {
 		uff.Errorf("Must supply at least one file as an argument")
 		return nil, fmt.Errorf("no files supplied as arguments")
 	}
-	if os.Getenv("GOPATH") == "" {
+	if uff.BuildMode != BuildModeModule && os.Getenv("GOPATH") == "" && !allFilesModuled(fileArgs) {
 		uff.Errorf("GOPATH environment varaible is not set")
 		return nil, fmt.Errorf("GOPATH not set")
 	}
 
+	// go/packages resolves import paths relative to a working directory, so
+	// anchor it to wherever the caller pointed us rather than this
+	// process's own cwd
+	if abs, err := filepath.Abs(fileArgs[0]); err == nil {
+		uff.loadDir = abs
+		if !isDir(uff.loadDir) {
+			uff.loadDir = filepath.Dir(uff.loadDir)
+		}
+	}
+
 	// first, get all the file names and package imports
 	uff.Logf("Collecting func declarations from source files")
 	for _, filename := range fileArgs {
@@ -266,25 +860,218 @@ func (uff *UnusedFuncFinder) Run(fileArgs []string) ([]UnusedThing, error) {
 	}
 	uff.Logf("Parsed %v source files", uff.numFilesRead)
 
-	if uff.Idents {
-		return uff.findUnusedIdents()
-	}
-
-	// then get the callgraph from the oracle
+	// then build the whole-program callgraph and collect declared
+	// methods/types/vars/consts/fields plus their references
 	uff.Logf("Running callgraph analysis on following packages: \n\t%v",
 		strings.Join(uff.pkgsAsArray(), "\n\t"))
-	if err := uff.getCallgraphFromOracle(); err != nil {
-		uff.Errorf("Error getting results from oracle: %v", err.Error())
+	if err := uff.analyzeProgram(); err != nil {
+		uff.Errorf("Error analyzing program: %v", err.Error())
 		return nil, err
 	}
 
 	// use that callgraph to build a callgraph->file map
 	uff.buildFileMap()
 
-	// finally, figure out which functions are not in the graph
-	uff.Logf("Scanning callgraph for unused functions")
-	unusedFuncs := uff.computeUnusedFuncs()
+	// finally, figure out which things are not in the graph or unreferenced
+	uff.Logf("Scanning for unused declarations")
+	unused := uff.computeUnusedThings()
 
 	uff.Logf("") // assure space between log output and results
-	return unusedFuncs, nil
+	return unused, nil
+}
+
+// findExtraRoots scans pkgs for reflect-, cgo-, and address-taken-based
+// usages that the static callgraph can't see as callers, plus any function
+// matching uff.RootPatterns, and returns the corresponding *ssa.Function
+// values so markReachable can treat them as roots.
+func (uff *UnusedFuncFinder) findExtraRoots(pkgs []*packages.Package, prog *ssa.Program) []*ssa.Function {
+	var patterns []*regexp.Regexp
+	for _, p := range uff.RootPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			uff.Errorf("invalid RootPatterns regex %q: %v", p, err)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+
+	var roots []*ssa.Function
+	reflectNames := map[string]bool{}
+	for _, pkg := range pkgs {
+		cgoObjs, pkgReflectNames, addrObjs := scanPackageForRoots(pkg)
+		for obj := range cgoObjs {
+			if fn := prog.FuncValue(obj); fn != nil {
+				roots = append(roots, fn)
+			}
+		}
+		for obj := range addrObjs {
+			if fn := prog.FuncValue(obj); fn != nil {
+				roots = append(roots, fn)
+			}
+		}
+		for name := range pkgReflectNames {
+			reflectNames[name] = true
+		}
+	}
+
+	for fn := range ssautil.AllFunctions(prog) {
+		if fn == nil {
+			continue
+		}
+		if fn.Signature.Recv() != nil && reflectNames[fn.Name()] {
+			roots = append(roots, fn)
+			continue
+		}
+		if len(patterns) > 0 && matchesAny(patterns, qualifiedName(fn)) {
+			roots = append(roots, fn)
+		}
+	}
+	return roots
+}
+
+// qualifiedName returns "pkg.Func" for fn, or just "Func" for synthetic
+// functions with no home package, for matching against RootPatterns.
+func qualifiedName(fn *ssa.Function) string {
+	if fn.Pkg == nil {
+		return fn.Name()
+	}
+	return fn.Pkg.Pkg.Path() + "." + fn.Name()
+}
+
+// scanPackageForRoots walks pkg's syntax trees for the three root heuristics
+// that need a single pass over the AST: cgo //export directives, string
+// literals passed to a reflect MethodByName call, and functions whose
+// address is taken in the initializer of an exported package-level var.
+func scanPackageForRoots(pkg *packages.Package) (cgoObjs map[*types.Func]bool, reflectNames map[string]bool, addrObjs map[*types.Func]bool) {
+	cgoObjs = map[*types.Func]bool{}
+	reflectNames = map[string]bool{}
+	addrObjs = map[*types.Func]bool{}
+	scope := pkg.Types.Scope()
+
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Doc == nil {
+					continue
+				}
+				for _, c := range d.Doc.List {
+					if _, ok := cgoExportName(c.Text); ok {
+						if obj, ok := pkg.TypesInfo.Defs[d.Name].(*types.Func); ok {
+							cgoObjs[obj] = true
+						}
+					}
+				}
+			case *ast.GenDecl:
+				// Only package-scope var decls count as the "exported var/map"
+				// an address can be stashed in; a *ast.GenDecl with Tok==VAR
+				// also matches a local var inside a function body, which
+				// ast.Inspect would otherwise walk into and which has nothing
+				// to do with the package's exported surface.
+				if d.Tok != token.VAR {
+					continue
+				}
+				for _, spec := range d.Specs {
+					vs, ok := spec.(*ast.ValueSpec)
+					if !ok {
+						continue
+					}
+					exported := false
+					for _, name := range vs.Names {
+						if obj := pkg.TypesInfo.Defs[name]; obj != nil && obj.Parent() == scope && name.IsExported() {
+							exported = true
+							break
+						}
+					}
+					if !exported {
+						continue
+					}
+					for _, val := range vs.Values {
+						ast.Inspect(val, func(n ast.Node) bool {
+							id, ok := n.(*ast.Ident)
+							if !ok {
+								return true
+							}
+							if obj, ok := pkg.TypesInfo.Uses[id].(*types.Func); ok {
+								addrObjs[obj] = true
+							}
+							return true
+						})
+					}
+				}
+			}
+		}
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if name, ok := reflectMethodByNameArg(call, pkg.TypesInfo); ok {
+				reflectNames[name] = true
+			}
+			return true
+		})
+	}
+	return cgoObjs, reflectNames, addrObjs
+}
+
+// cgoExportName parses a single "//export Name" cgo directive comment (see
+// https://pkg.go.dev/cmd/cgo), returning the exported name if c is one.
+func cgoExportName(comment string) (string, bool) {
+	const prefix = "//export "
+	if !strings.HasPrefix(comment, prefix) {
+		return "", false
+	}
+	name := strings.TrimSpace(comment[len(prefix):])
+	if name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// reflectMethodByNameArg reports the string literal argument of a
+// reflect.Value.MethodByName or reflect.Type.MethodByName call, if call is
+// one.
+func reflectMethodByNameArg(call *ast.CallExpr, info *types.Info) (string, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "MethodByName" {
+		return "", false
+	}
+	if !isReflectType(info.TypeOf(sel.X)) {
+		return "", false
+	}
+	if len(call.Args) != 1 {
+		return "", false
+	}
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	name, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return "", false
+	}
+	return name, true
+}
+
+// isReflectType reports whether t is a named type declared in package
+// reflect, e.g. reflect.Value or reflect.Type.
+func isReflectType(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj != nil && obj.Pkg() != nil && obj.Pkg().Path() == "reflect"
+}
+
+// matchesAny reports whether s matches any of res.
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
 }
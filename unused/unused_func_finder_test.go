@@ -0,0 +1,120 @@
+package unused
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFixtureModule writes a throwaway module under t.TempDir() with the
+// given files (relative path -> contents) and returns its root directory.
+func writeFixtureModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for rel, contents := range files {
+		full := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestUnusedMethodWrapperLiveness covers the go/ssa wrapper bug: a wrapper
+// synthesized for the *T method set of an exported type shares its Pos()
+// with the real method and has a static edge into it, even when the
+// wrapper itself is never called. Under ExportedOnly (which stops exported
+// names from being auto-rooted), that phantom edge must not make a
+// never-called exported method look live.
+func TestUnusedMethodWrapperLiveness(t *testing.T) {
+	dir := writeFixtureModule(t, map[string]string{
+		"go.mod": "module fixture\n\ngo 1.21\n",
+		"main.go": `package main
+
+type Thing struct{ Live int }
+
+func (t Thing) LiveMethod() int { return t.Live }
+
+func (t Thing) DeadMethod() int { return t.Live + 1 }
+
+func main() {
+	var th Thing
+	_ = th.LiveMethod()
+}
+`,
+	})
+
+	uff := NewUnusedFunctionFinder()
+	uff.ExportedOnly = true
+	uff.Verbose = false
+	things, err := uff.Run([]string{dir})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var gotDead, gotLive bool
+	for _, th := range things {
+		switch th.Name {
+		case "DeadMethod":
+			gotDead = true
+		case "LiveMethod":
+			gotLive = true
+		}
+	}
+	if !gotDead {
+		t.Errorf("DeadMethod should be reported unused, wasn't (things: %v)", things)
+	}
+	if gotLive {
+		t.Errorf("LiveMethod is called from main and should not be reported unused")
+	}
+}
+
+// TestUnusedTaggedFieldIsRoot covers the promised reflect-tagged-struct-field
+// root: under ExportedOnly, an unexported field that only encoding/json
+// reaches via its tag must not be reported unused.
+func TestUnusedTaggedFieldIsRoot(t *testing.T) {
+	dir := writeFixtureModule(t, map[string]string{
+		"go.mod": "module fixture\n\ngo 1.21\n",
+		"main.go": `package main
+
+import "encoding/json"
+
+type thing struct {
+	name string ` + "`json:\"name\"`" + `
+	dead string
+}
+
+func main() {
+	var th thing
+	b, _ := json.Marshal(th)
+	_ = b
+}
+`,
+	})
+
+	uff := NewUnusedFunctionFinder()
+	uff.ExportedOnly = true
+	things, err := uff.Run([]string{dir})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var gotDead, gotName bool
+	for _, th := range things {
+		switch th.Name {
+		case "dead":
+			gotDead = true
+		case "name":
+			gotName = true
+		}
+	}
+	if !gotDead {
+		t.Errorf("dead field should be reported unused, wasn't (things: %v)", things)
+	}
+	if gotName {
+		t.Errorf("name field carries a json tag and should be rooted, not reported unused")
+	}
+}